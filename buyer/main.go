@@ -1,76 +1,147 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis"
+
+	"github.com/cloud66-samples/acme/pkg/queue"
+	"github.com/cloud66-samples/acme/pkg/redisclient"
+	"github.com/cloud66-samples/acme/pkg/runtime"
 )
 
 const (
-	interval = 1 * time.Second
-	keyName  = "acme:queue"
+	interval        = 1 * time.Second
+	keyName         = "acme:queue"
+	group           = "acme:buyers"
+	recoverInterval = 5 * time.Second
+	recoverMinIdle  = 30 * time.Second
 )
 
 var (
-	redisAddress string
-	client       *redis.Client
+	redisConn    string
+	queueBackend string
+	client       redis.UniversalClient
+	consumer     queue.Consumer
 )
 
 // this pushes new items onto a stack on a random cycle
 func main() {
-	flag.StringVar(&redisAddress, "redis", "localhost:6379", "Connection to redis")
+	flag.StringVar(&redisConn, "redis", "addrs=localhost:6379 db=0", "Redis connection string, e.g. \"addrs=host1:6379,host2:6379 db=0 sentinel_master=mymaster password=secret tls=true\"")
+	flag.StringVar(&queueBackend, "queue-backend", queue.ListBackend, "Queue backend to use: list or streams")
 	flag.Parse()
 
-	cancel := make(chan os.Signal)
-	signal.Notify(cancel, os.Interrupt, syscall.SIGTERM)
+	rand.Seed(time.Now().Unix())
 
 	err := setupClient()
 	if err != nil {
-		fmt.Printf("Failed to connect to Redis on %s due to %s\n", redisAddress, err.Error())
+		fmt.Printf("Failed to connect to Redis on %s due to %s\n", redisConn, err.Error())
 		os.Exit(1)
 	}
 
-	ticker := time.NewTicker(interval)
-	for {
-		select {
-		case <-cancel:
-			fmt.Println("Leaving...")
-			ticker.Stop()
-			os.Exit(1)
-		case <-ticker.C:
+	err = setupQueue()
+	if err != nil {
+		fmt.Printf("Failed to set up %s queue backend due to %s\n", queueBackend, err.Error())
+		os.Exit(1)
+	}
+
+	err = runtime.Run(runtime.Options{
+		Interval: interval,
+		Tick: func(ctx context.Context) error {
 			fmt.Println("Buying...")
-			err := buy()
-			if err != nil {
+			if err := buy(); err != nil {
 				fmt.Println("Nothing to buy")
 			}
-		}
+			return nil
+		},
+		Background: []func(ctx context.Context){
+			func(ctx context.Context) { recoverLoop(ctx, recoverInterval) },
+		},
+		Client: client,
+	})
+	if err != nil {
+		fmt.Printf("Shutdown failed due to %s\n", err.Error())
+		os.Exit(1)
 	}
+
+	fmt.Println("Leaving...")
 }
 
 func setupClient() error {
-	client = redis.NewClient(&redis.Options{
-		Addr:     redisAddress,
-		Password: "",
-		DB:       0,
-	})
+	fmt.Printf("Connecting to Redis on %s\n", redisConn)
 
-	fmt.Printf("Connecting to Redis on %s\n", redisAddress)
-	_, err := client.Ping().Result()
+	var err error
+	client, err = redisclient.New(redisConn)
 	return err
 }
 
+func setupQueue() error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = strconv.Itoa(os.Getpid())
+	}
+
+	_, consumer, err = queue.New(client, queue.Config{
+		Backend:       queueBackend,
+		Key:           keyName,
+		Group:         group,
+		Consumer:      hostname,
+		MaxLen:        10000,
+		MaxDeliveries: 5,
+	})
+	return err
+}
+
+// buy pulls a random-sized batch of messages in a single round trip,
+// mirroring the seller's batched pushes, and acks each as it's handled.
 func buy() error {
-	result, err := client.LPop(keyName).Result()
+	count := rand.Intn(10)
+
+	msgs, err := consumer.ReceiveBatch(int64(count))
 	if err != nil {
 		return err
 	}
+	if len(msgs) == 0 {
+		return queue.ErrEmpty
+	}
 
-	fmt.Println(result)
+	for _, msg := range msgs {
+		fmt.Println(msg.Payload)
+		if err := consumer.Ack(msg.ID); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
+
+// recoverLoop reclaims messages left pending by a crashed consumer every
+// interval until ctx is cancelled, dead-lettering any that have exceeded
+// their delivery attempt budget along the way. It's a no-op against the
+// list backend, which has no pending-entries concept.
+func recoverLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			claimed, err := consumer.Recover(recoverMinIdle)
+			if err != nil {
+				fmt.Printf("Failed to recover pending messages due to %s\n", err.Error())
+				continue
+			}
+			if claimed > 0 {
+				fmt.Printf("Reclaimed %d pending message(s)\n", claimed)
+			}
+		}
+	}
+}
@@ -10,12 +10,18 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
-	"os/signal"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cloud66-samples/acme/pkg/redisclient"
+	"github.com/cloud66-samples/acme/pkg/runtime"
+	"github.com/cloud66-samples/acme/pkg/sizecache"
 )
 
 type key int
@@ -30,27 +36,47 @@ const (
 )
 
 var (
-	redisAddress string
-	client       *redis.Client
+	redisConn    string
+	client       redis.UniversalClient
 	listenAddr   string
 	healthy      int32
+	otelEndpoint string
+	otelInsecure bool
+	cacheTTL     time.Duration
+	sizeCache    *sizecache.Cache
+	gracePeriod  time.Duration
 )
 
 // this pushes new items onto a stack on a random cycle
 func main() {
-	flag.StringVar(&redisAddress, "redis", "localhost:6379", "Connection to redis")
+	flag.StringVar(&redisConn, "redis", "addrs=localhost:6379 db=0", "Redis connection string, e.g. \"addrs=host1:6379,host2:6379 db=0 sentinel_master=mymaster password=secret tls=true\"")
 	flag.StringVar(&listenAddr, "binding", "0.0.0.0:5000", "Server listen address")
+	flag.StringVar(&otelEndpoint, "otel-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/gRPC collector endpoint, e.g. localhost:4317; empty disables tracing")
+	flag.BoolVar(&otelInsecure, "otel-insecure", os.Getenv("OTEL_EXPORTER_OTLP_INSECURE") == "true", "Disable TLS when talking to the OTLP collector")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 500*time.Millisecond, "How long a cached /size value is served before going back to Redis; also the background refresh interval")
+	flag.DurationVar(&gracePeriod, "grace-period", 30*time.Second, "How long to wait for in-flight requests to drain on shutdown")
 
 	flag.Parse()
 
-	cancel := make(chan os.Signal)
-	signal.Notify(cancel, os.Interrupt, syscall.SIGTERM)
-
 	rand.Seed(time.Now().Unix())
 
-	err := setupClient()
+	ctx := context.Background()
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		fmt.Printf("Failed to set up tracing due to %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer shutdownTracing(ctx)
+
+	err = setupClient()
+	if err != nil {
+		fmt.Printf("Failed to connect to Redis on %s due to %s\n", redisConn, err.Error())
+		os.Exit(1)
+	}
+
+	sizeCache, err = sizecache.New(keyName, fetch, cacheTTL)
 	if err != nil {
-		fmt.Printf("Failed to connect to Redis on %s due to %s\n", redisAddress, err.Error())
+		fmt.Printf("Failed to set up size cache due to %s\n", err.Error())
 		os.Exit(1)
 	}
 
@@ -62,6 +88,8 @@ func main() {
 	router.Handle("/size", sizeRoute())
 	router.Handle("/histogram", histogramRoute())
 	router.Handle("/healthz", healthz())
+	router.Handle("/metrics", metricsRoute())
+	router.Handle("/stream", streamRoute())
 
 	nextRequestID := func() string {
 		return fmt.Sprintf("%d", time.Now().UnixNano())
@@ -69,65 +97,65 @@ func main() {
 
 	server := &http.Server{
 		Addr:         listenAddr,
-		Handler:      tracing(nextRequestID)(logging(logger)(router)),
+		Handler:      metrics()(tracing(nextRequestID)(logging(logger)(router))),
 		ErrorLog:     logger,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  15 * time.Second,
 	}
 
-	done := make(chan bool)
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-
 	seed := rand.Float64() + SeedBase
 
-	go func() {
-		ticker := time.NewTicker(interval)
-		for {
-			select {
-			case <-quit:
-				fmt.Println("Leaving...")
-				ticker.Stop()
-				os.Exit(1)
-			case <-ticker.C:
-				fmt.Println("Getting tickers...")
-				dp, close := createDatapoint(seed)
-				seed = close
-				dpJSON, err := json.Marshal(dp)
-				if err != nil {
-					fmt.Printf("Failed to serialise tickers due to %s\n", err.Error())
-				}
-				_, err = client.LPush(histogram, dpJSON).Result()
-				if err != nil {
-					fmt.Printf("Failed to write tickers due to %s\n", err.Error())
-				}
-			}
-		}
-	}()
+	logger.Println("Server is ready to handle requests at", listenAddr)
+	atomic.StoreInt32(&healthy, 1)
 
-	go func() {
-		<-quit
-		logger.Println("Server is shutting down...")
-		atomic.StoreInt32(&healthy, 0)
+	err = runtime.Run(runtime.Options{
+		Interval: interval,
+		Tick: func(ctx context.Context) error {
+			fmt.Println("Getting tickers...")
+			dp, close := createDatapoint(seed)
+			seed = close
+			dpJSON, err := json.Marshal(dp)
+			if err != nil {
+				fmt.Printf("Failed to serialise tickers due to %s\n", err.Error())
+				return err
+			}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+			err = timeRedisOp(ctx, "lpush", func() error {
+				_, err := client.LPush(histogram, dpJSON).Result()
+				return err
+			})
+			if err != nil {
+				fmt.Printf("Failed to write tickers due to %s\n", err.Error())
+			}
 
-		server.SetKeepAlivesEnabled(false)
-		if err := server.Shutdown(ctx); err != nil {
-			logger.Fatalf("Could not gracefully shutdown the server: %v\n", err)
-		}
-		close(done)
-	}()
+			err = timeRedisOp(ctx, "publish", func() error {
+				return client.Publish(ticksChannel, dpJSON).Err()
+			})
+			if err != nil {
+				fmt.Printf("Failed to publish tick due to %s\n", err.Error())
+			}
+			return nil
+		},
+		Server: server,
+		OnShutdown: func() {
+			logger.Println("Server is shutting down...")
+			atomic.StoreInt32(&healthy, 0)
+			server.SetKeepAlivesEnabled(false)
+		},
+		Background: []func(ctx context.Context){
+			func(ctx context.Context) { sampleQueueDepth(ctx, interval) },
+			func(ctx context.Context) { sizeCache.Run(ctx, cacheTTL) },
+		},
+		Client:      client,
+		GracePeriod: gracePeriod,
+	})
 
-	logger.Println("Server is ready to handle requests at", listenAddr)
-	atomic.StoreInt32(&healthy, 1)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Fatalf("Could not listen on %s: %v\n", listenAddr, err)
+	if err != nil {
+		logger.Printf("Shutdown failed due to %s\n", err.Error())
+		os.Exit(1)
 	}
 
-	<-done
 	logger.Println("Server stopped")
 }
 
@@ -180,19 +208,25 @@ func getDiv() float64 {
 }
 
 func setupClient() error {
-	client = redis.NewClient(&redis.Options{
-		Addr:     redisAddress,
-		Password: "",
-		DB:       0,
-	})
+	fmt.Printf("Connecting to Redis on %s\n", redisConn)
 
-	fmt.Printf("Connecting to Redis on %s\n", redisAddress)
-	_, err := client.Ping().Result()
+	var err error
+	client, err = redisclient.New(redisConn)
 	return err
 }
 
+// fetch is sizeCache's Fetcher, called by its background refresh loop,
+// on a cache miss from sizeRoute, and by sampleQueueDepth. Fetcher's
+// signature carries no context, so this is rooted on
+// context.Background(); an LLEN made on a sizeRoute cache miss is not
+// nested under that request's span.
 func fetch() (int64, error) {
-	size, err := client.LLen(keyName).Result()
+	var size int64
+	err := timeRedisOp(context.Background(), "llen", func() error {
+		var err error
+		size, err = client.LLen(keyName).Result()
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -206,7 +240,7 @@ func sizeRoute() http.Handler {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.WriteHeader(http.StatusOK)
 
-		size, _ := fetch()
+		size, _ := sizeCache.Get()
 		fmt.Fprintf(w, "{ \"size\": %d }", size)
 	})
 }
@@ -217,7 +251,12 @@ func histogramRoute() http.Handler {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.WriteHeader(http.StatusOK)
 
-		ticker, _ := client.RPop(histogram).Result()
+		var ticker string
+		_ = timeRedisOp(r.Context(), "rpop", func() error {
+			var err error
+			ticker, err = client.RPop(histogram).Result()
+			return err
+		})
 		var day []float64
 		fmt.Printf("Ticker %s\n", ticker)
 		err := json.Unmarshal([]byte(ticker), &day)
@@ -262,7 +301,22 @@ func tracing(nextRequestID func() string) func(http.Handler) http.Handler {
 			}
 			ctx := context.WithValue(r.Context(), requestIDKey, requestID)
 			w.Header().Set("X-Request-Id", requestID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+
+			ctx = propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.URL.Path, trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+				attribute.String("acme.request_id", requestID),
+			))
+			defer span.End()
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			span.SetAttributes(attribute.Int("http.status_code", rec.status))
+			if rec.status >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(rec.status))
+			}
 		})
 	}
 }
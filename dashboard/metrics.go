@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "acme_http_request_duration_seconds",
+		Help:    "Latency of HTTP handlers, by path, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path", "method", "status"})
+
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "acme_http_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	})
+
+	redisOpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "acme_redis_op_duration_seconds",
+		Help:    "Latency of Redis operations issued by the dashboard, by command.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op"})
+
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "acme_queue_depth",
+		Help: "Most recently sampled length of the acme:queue list.",
+	})
+
+	itemsSold = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "acme_items_sold_total",
+		Help: "Items inferred as sold from increases in queue depth between samples.",
+	})
+
+	itemsBought = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "acme_items_bought_total",
+		Help: "Items inferred as bought from decreases in queue depth between samples.",
+	})
+
+	cacheHitRatio = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "acme_sizecache_hit_ratio",
+		Help: "Ratio of sizecache hits to total Get calls since startup.",
+	}, func() float64 {
+		hits, misses := sizeCache.Stats()
+		total := hits + misses
+		if total == 0 {
+			return 0
+		}
+		return float64(hits) / float64(total)
+	})
+)
+
+// statusRecorder captures the status code written by the wrapped
+// handler so middleware can label metrics and spans with it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes through to the wrapped writer, so streaming
+// handlers such as streamRoute still see an http.Flusher.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// metrics records request latency, status code and in-flight count for
+// every request.
+func metrics() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httpRequestsInFlight.Inc()
+			defer httpRequestsInFlight.Dec()
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(rec, r)
+
+			httpRequestDuration.
+				WithLabelValues(r.URL.Path, r.Method, strconv.Itoa(rec.status)).
+				Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+func metricsRoute() http.Handler {
+	return promhttp.Handler()
+}
+
+// timeRedisOp records how long a Redis operation labelled op took and
+// wraps it in a span child of ctx, so it shows up nested under whichever
+// request span (if any) triggered it. go-redis v6 predates context-aware
+// commands, so this is done at each call site rather than in a client
+// hook, which has no access to the caller's context.
+func timeRedisOp(ctx context.Context, op string, fn func() error) error {
+	_, span := tracer.Start(ctx, "redis."+op)
+	defer span.End()
+
+	start := time.Now()
+	err := fn()
+	redisOpDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// sampleQueueDepth polls the queue length on interval until ctx is
+// cancelled, updating queueDepth and inferring itemsSold/itemsBought
+// from the change since the last sample. sell and buy run in separate
+// processes, so this is an estimate rather than an exact count.
+func sampleQueueDepth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := int64(-1)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			depth, err := fetch()
+			if err != nil {
+				continue
+			}
+
+			queueDepth.Set(float64(depth))
+			if last >= 0 {
+				switch {
+				case depth > last:
+					itemsSold.Add(float64(depth - last))
+				case depth < last:
+					itemsBought.Add(float64(last - depth))
+				}
+			}
+			last = depth
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// ticksChannel is where the ticker goroutine publishes every
+	// datapoint for /stream subscribers.
+	ticksChannel = "acme:ticks"
+	// streamReplayN is how many historical datapoints a new connection
+	// is sent before it switches to the live feed.
+	streamReplayN = 20
+	// streamHeartbeat is how often a comment-only frame is sent to keep
+	// idle connections (and intermediate proxies) from timing out.
+	streamHeartbeat = 15 * time.Second
+)
+
+// streamRoute serves a live feed of datapoints over Server-Sent Events:
+// recent history first via LRANGE, then every new tick published to
+// ticksChannel until the client disconnects.
+func streamRoute() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		for _, point := range replay(r.Context(), streamReplayN) {
+			fmt.Fprintf(w, "data: %s\n\n", point)
+		}
+		flusher.Flush()
+
+		sub := client.Subscribe(ticksChannel)
+		defer sub.Close()
+		ticks := sub.Channel()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case msg, ok := <-ticks:
+				if !ok {
+					return
+				}
+				fmt.Fprintf(w, "data: %s\n\n", msg.Payload)
+				flusher.Flush()
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+// replay returns up to n historical datapoints from the histogram list,
+// oldest first, so a newly connected client has context before it
+// starts receiving live ticks.
+func replay(ctx context.Context, n int64) []string {
+	var points []string
+	err := timeRedisOp(ctx, "lrange", func() error {
+		var err error
+		points, err = client.LRange(histogram, 0, n-1).Result()
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+
+	for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+		points[i], points[j] = points[j], points[i]
+	}
+
+	return points
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// pushBatchScript atomically LPUSHes ARGV[2] onto KEYS[1] ARGV[1] times
+// and returns the resulting length, so a batch push is one round trip
+// with no partial-failure window.
+var pushBatchScript = redis.NewScript(`
+for i = 1, tonumber(ARGV[1]) do
+	redis.call('LPUSH', KEYS[1], ARGV[2])
+end
+return redis.call('LLEN', KEYS[1])
+`)
+
+// receiveBatchScript wraps LPOP's count form: go-redis v6 doesn't expose
+// it directly (it predates Redis 6.2), but Eval can issue any command the
+// server understands.
+var receiveBatchScript = redis.NewScript(`return redis.call('LPOP', KEYS[1], ARGV[1])`)
+
+// listSellBatchScript atomically LPUSHes ARGV[1] copies of ARGV[2] onto
+// KEYS[1], then LPUSHes ARGV[1] itself onto the histogram at KEYS[2] and
+// trims it to ARGV[3] entries, all in one round trip. A count of zero
+// skips the queue pushes but still records the zero in the histogram, so
+// the sampled-every-tick histogram keeps one entry per tick.
+var listSellBatchScript = redis.NewScript(`
+local count = tonumber(ARGV[1])
+if count > 0 then
+	for i = 1, count do
+		redis.call('LPUSH', KEYS[1], ARGV[2])
+	end
+end
+redis.call('LPUSH', KEYS[2], ARGV[1])
+redis.call('LTRIM', KEYS[2], 0, tonumber(ARGV[3]) - 1)
+return redis.call('LLEN', KEYS[1])
+`)
+
+// ListQueue is the original backend: a plain Redis list driven by
+// LPUSH/LPOP. It has no redelivery semantics, so a consumer that crashes
+// after LPOP but before finishing its work loses that item. It exists so
+// deployments with data already sitting in the list key keep working.
+type ListQueue struct {
+	client redis.UniversalClient
+	key    string
+}
+
+// NewListQueue returns a Producer/Consumer backed by the Redis list at
+// key.
+func NewListQueue(client redis.UniversalClient, key string) *ListQueue {
+	return &ListQueue{client: client, key: key}
+}
+
+// Push implements Producer.
+func (q *ListQueue) Push(payload string) (int64, error) {
+	return q.client.LPush(q.key, payload).Result()
+}
+
+// PushBatch implements Producer.
+func (q *ListQueue) PushBatch(payload string, count int64) (int64, error) {
+	if count == 0 {
+		return q.client.LLen(q.key).Result()
+	}
+
+	return pushBatchScript.Run(q.client, []string{q.key}, count, payload).Int64()
+}
+
+// PushSellBatch implements Producer.
+func (q *ListQueue) PushSellBatch(payload string, count int64, histogramKey string, maxHistogramLen int64) (int64, error) {
+	return listSellBatchScript.Run(q.client, []string{q.key, histogramKey}, count, payload, maxHistogramLen).Int64()
+}
+
+// Receive implements Consumer.
+func (q *ListQueue) Receive() (Message, error) {
+	payload, err := q.client.LPop(q.key).Result()
+	if err == redis.Nil {
+		return Message{}, ErrEmpty
+	}
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{Payload: payload}, nil
+}
+
+// ReceiveBatch implements Consumer.
+func (q *ListQueue) ReceiveBatch(count int64) ([]Message, error) {
+	if count == 0 {
+		return nil, nil
+	}
+
+	val, err := receiveBatchScript.Run(q.client, []string{q.key}, count).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := val.([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	msgs := make([]Message, len(items))
+	for i, item := range items {
+		payload, _ := item.(string)
+		msgs[i] = Message{Payload: payload}
+	}
+	return msgs, nil
+}
+
+// Ack is a no-op: the list backend has nothing left to acknowledge once
+// LPOP has returned an item.
+func (q *ListQueue) Ack(id string) error {
+	return nil
+}
+
+// Recover is a no-op: the list backend has no pending-entries concept to
+// recover from.
+func (q *ListQueue) Recover(minIdle time.Duration) (int, error) {
+	return 0, nil
+}
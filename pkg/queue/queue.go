@@ -0,0 +1,119 @@
+// Package queue provides pluggable producer/consumer backends for the
+// work queue shared by the seller and buyer services. The original
+// implementation pushed and popped directly against a Redis list; that
+// backend is kept as ListBackend for existing deployments, alongside a
+// Redis Streams backend that adds consumer groups and at-least-once
+// delivery.
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Backend names accepted by the --queue-backend flag.
+const (
+	ListBackend    = "list"
+	StreamsBackend = "streams"
+)
+
+// ErrEmpty is returned by Consumer.Receive when there is currently no
+// message available.
+var ErrEmpty = errors.New("queue: no message available")
+
+// Message is a single unit of work read from the queue. ID is opaque to
+// callers; list-backed queues leave it empty, and streams-backed queues
+// set it to the message's stream ID so it can be passed back to Ack.
+type Message struct {
+	ID      string
+	Payload string
+}
+
+// Producer appends work to the queue.
+type Producer interface {
+	// Push enqueues payload and returns the resulting queue length.
+	Push(payload string) (int64, error)
+
+	// PushBatch enqueues count copies of payload in a single round trip
+	// and returns the resulting queue length. ListQueue does this
+	// atomically, so callers never observe a state where some but not
+	// all of the count copies have landed; StreamsQueue pipelines the
+	// writes, so a dropped connection can leave part of a batch applied.
+	PushBatch(payload string, count int64) (int64, error)
+
+	// PushSellBatch is PushBatch plus a record of the sale: in the same
+	// round trip it appends count to histogramKey and trims it to
+	// maxHistogramLen entries. Folding both into one script removes the
+	// partial-failure window between a batch landing on the queue and
+	// the histogram update that's supposed to describe it.
+	PushSellBatch(payload string, count int64, histogramKey string, maxHistogramLen int64) (int64, error)
+}
+
+// Consumer reads work from the queue.
+type Consumer interface {
+	// Receive returns the next message, or ErrEmpty if none is
+	// currently available.
+	Receive() (Message, error)
+
+	// ReceiveBatch returns up to count of the next messages in a single
+	// round trip. It returns fewer than count, including none, if that's
+	// all that's currently available; unlike Receive it does not treat
+	// an empty queue as an error.
+	ReceiveBatch(count int64) ([]Message, error)
+
+	// Ack marks a message as successfully processed. Backends without
+	// redelivery semantics treat this as a no-op.
+	Ack(id string) error
+
+	// Recover reclaims messages that have been pending for longer than
+	// minIdle, handing them back to this consumer, and dead-letters any
+	// that have exceeded their delivery attempt budget. It returns how
+	// many messages were reclaimed. Backends without redelivery
+	// semantics treat this as a no-op.
+	Recover(minIdle time.Duration) (int, error)
+}
+
+// Config selects and configures a backend for New.
+type Config struct {
+	// Backend is one of ListBackend or StreamsBackend. Defaults to
+	// ListBackend.
+	Backend string
+	// Key is the queue's Redis key (list key or stream key).
+	Key string
+	// Group and Consumer identify this process within a consumer group.
+	// Only used by StreamsBackend, and only needed by a caller that will
+	// read from the queue; leave both empty for a producer-only caller
+	// so no unused consumer group gets created.
+	Group, Consumer string
+	// MaxLen and MaxDeliveries configure trimming and dead-lettering.
+	// Only used by StreamsBackend.
+	MaxLen, MaxDeliveries int64
+}
+
+// New constructs the Producer/Consumer pair selected by cfg.Backend. The
+// returned values implement both interfaces over the same underlying
+// queue.
+func New(client redis.UniversalClient, cfg Config) (Producer, Consumer, error) {
+	switch cfg.Backend {
+	case StreamsBackend:
+		q, err := NewStreamsQueue(client, StreamsConfig{
+			Key:           cfg.Key,
+			Group:         cfg.Group,
+			Consumer:      cfg.Consumer,
+			MaxLen:        cfg.MaxLen,
+			MaxDeliveries: cfg.MaxDeliveries,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return q, q, nil
+	case ListBackend, "":
+		q := NewListQueue(client, cfg.Key)
+		return q, q, nil
+	default:
+		return nil, nil, fmt.Errorf("queue: unknown backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,278 @@
+package queue
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// DeadLetterSuffix names the dead-letter stream relative to the main
+// stream key, e.g. "acme:queue" dead-letters to "acme:queue:dead".
+const DeadLetterSuffix = ":dead"
+
+// defaultMaxDeliveries is how many times a message is delivered, counting
+// its first delivery and each reclaim by Recover, before it is moved to
+// the dead-letter stream.
+const defaultMaxDeliveries = 5
+
+// payloadField is the single field used to carry the message body in
+// each stream entry.
+const payloadField = "payload"
+
+// StreamsConfig configures a StreamsQueue.
+type StreamsConfig struct {
+	// Key is the stream name, e.g. "acme:queue".
+	Key string
+	// Group is the consumer group name. It is created on first use if
+	// it doesn't already exist.
+	Group string
+	// Consumer identifies this process within Group, e.g. a hostname or
+	// pid, so pending entries can be attributed and reclaimed.
+	Consumer string
+	// MaxLen is the approximate MAXLEN applied on every XADD, trimming
+	// old entries. Zero disables trimming.
+	MaxLen int64
+	// MaxDeliveries is how many times a message may be delivered,
+	// counting its first delivery and each reclaim by Recover, before it
+	// is dead-lettered. Defaults to 5.
+	MaxDeliveries int64
+}
+
+// StreamsQueue is a Producer/Consumer backed by a Redis stream and
+// consumer group, giving at-least-once delivery: a message stays pending
+// until Ack is called, so Recover can redeliver it to another consumer
+// after a crash.
+type StreamsQueue struct {
+	client redis.UniversalClient
+	cfg    StreamsConfig
+}
+
+// NewStreamsQueue returns a StreamsQueue, creating the stream and
+// consumer group if they don't already exist. cfg.Group may be left
+// empty for a producer-only caller that will only ever call Push,
+// PushBatch or PushSellBatch; no consumer group is created in that case,
+// since nothing would ever read from or advance it.
+func NewStreamsQueue(client redis.UniversalClient, cfg StreamsConfig) (*StreamsQueue, error) {
+	if cfg.MaxDeliveries == 0 {
+		cfg.MaxDeliveries = defaultMaxDeliveries
+	}
+
+	if cfg.Group != "" {
+		err := client.XGroupCreateMkStream(cfg.Key, cfg.Group, "0").Err()
+		if err != nil && !isBusyGroup(err) {
+			return nil, err
+		}
+	}
+
+	return &StreamsQueue{client: client, cfg: cfg}, nil
+}
+
+// isBusyGroup reports whether err is Redis' BUSYGROUP error, returned
+// when the consumer group already exists.
+func isBusyGroup(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "BUSYGROUP")
+}
+
+// Push implements Producer.
+func (q *StreamsQueue) Push(payload string) (int64, error) {
+	_, err := q.client.XAdd(&redis.XAddArgs{
+		Stream:       q.cfg.Key,
+		MaxLenApprox: q.cfg.MaxLen,
+		Values:       map[string]interface{}{payloadField: payload},
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return q.client.XLen(q.cfg.Key).Result()
+}
+
+// PushBatch implements Producer, pipelining count XAdd calls so they
+// share a single round trip. Unlike ListQueue.PushBatch this isn't
+// atomic — a pipeline can partially apply if the connection drops
+// mid-batch — but a dropped stream entry is no worse than a dropped
+// Push, and the consumer group's at-least-once delivery is unaffected
+// either way.
+func (q *StreamsQueue) PushBatch(payload string, count int64) (int64, error) {
+	if count == 0 {
+		return q.client.XLen(q.cfg.Key).Result()
+	}
+
+	var lenCmd *redis.IntCmd
+	_, err := q.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i := int64(0); i < count; i++ {
+			pipe.XAdd(&redis.XAddArgs{
+				Stream:       q.cfg.Key,
+				MaxLenApprox: q.cfg.MaxLen,
+				Values:       map[string]interface{}{payloadField: payload},
+			})
+		}
+		lenCmd = pipe.XLen(q.cfg.Key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return lenCmd.Result()
+}
+
+// streamsSellBatchScript is StreamsQueue's equivalent of the list backend's
+// script: it XADDs ARGV[1] copies of ARGV[2] onto KEYS[1], applying
+// MAXLEN trimming if ARGV[4] is non-zero, then LPUSHes ARGV[1] onto the
+// histogram at KEYS[2] and trims it to ARGV[3] entries, all in one round
+// trip. A count of zero skips the XADDs but still records the zero in
+// the histogram.
+var streamsSellBatchScript = redis.NewScript(`
+local count = tonumber(ARGV[1])
+local maxlen = tonumber(ARGV[4])
+if count > 0 then
+	for i = 1, count do
+		if maxlen > 0 then
+			redis.call('XADD', KEYS[1], 'MAXLEN', '~', maxlen, '*', 'payload', ARGV[2])
+		else
+			redis.call('XADD', KEYS[1], '*', 'payload', ARGV[2])
+		end
+	end
+end
+redis.call('LPUSH', KEYS[2], ARGV[1])
+redis.call('LTRIM', KEYS[2], 0, tonumber(ARGV[3]) - 1)
+return redis.call('XLEN', KEYS[1])
+`)
+
+// PushSellBatch implements Producer.
+func (q *StreamsQueue) PushSellBatch(payload string, count int64, histogramKey string, maxHistogramLen int64) (int64, error) {
+	return streamsSellBatchScript.Run(q.client, []string{q.cfg.Key, histogramKey}, count, payload, maxHistogramLen, q.cfg.MaxLen).Int64()
+}
+
+// Receive implements Consumer, reading new entries for this consumer's
+// group via XREADGROUP.
+func (q *StreamsQueue) Receive() (Message, error) {
+	streams, err := q.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    q.cfg.Group,
+		Consumer: q.cfg.Consumer,
+		Streams:  []string{q.cfg.Key, ">"},
+		Count:    1,
+		Block:    -1,
+	}).Result()
+	if err == redis.Nil {
+		return Message{}, ErrEmpty
+	}
+	if err != nil {
+		return Message{}, err
+	}
+
+	for _, stream := range streams {
+		for _, m := range stream.Messages {
+			payload, _ := m.Values[payloadField].(string)
+			return Message{ID: m.ID, Payload: payload}, nil
+		}
+	}
+
+	return Message{}, ErrEmpty
+}
+
+// ReceiveBatch implements Consumer, reading up to count new entries for
+// this consumer's group in a single XREADGROUP call.
+func (q *StreamsQueue) ReceiveBatch(count int64) ([]Message, error) {
+	if count == 0 {
+		return nil, nil
+	}
+
+	streams, err := q.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    q.cfg.Group,
+		Consumer: q.cfg.Consumer,
+		Streams:  []string{q.cfg.Key, ">"},
+		Count:    count,
+		Block:    -1,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []Message
+	for _, stream := range streams {
+		for _, m := range stream.Messages {
+			payload, _ := m.Values[payloadField].(string)
+			msgs = append(msgs, Message{ID: m.ID, Payload: payload})
+		}
+	}
+	return msgs, nil
+}
+
+// Ack implements Consumer.
+func (q *StreamsQueue) Ack(id string) error {
+	return q.client.XAck(q.cfg.Key, q.cfg.Group, id).Err()
+}
+
+// Recover implements Consumer using XPENDING to find entries idle for at
+// least minIdle, then either dead-lettering them (if they've exhausted
+// cfg.MaxDeliveries) or reclaiming them onto this consumer via XCLAIM.
+func (q *StreamsQueue) Recover(minIdle time.Duration) (int, error) {
+	pending, err := q.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: q.cfg.Key,
+		Group:  q.cfg.Group,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var toClaim []string
+	for _, p := range pending {
+		if p.Idle < minIdle {
+			continue
+		}
+		if p.RetryCount >= q.cfg.MaxDeliveries {
+			if err := q.deadLetter(p.Id); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		toClaim = append(toClaim, p.Id)
+	}
+	if len(toClaim) == 0 {
+		return 0, nil
+	}
+
+	claimed, err := q.client.XClaim(&redis.XClaimArgs{
+		Stream:   q.cfg.Key,
+		Group:    q.cfg.Group,
+		Consumer: q.cfg.Consumer,
+		MinIdle:  minIdle,
+		Messages: toClaim,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(claimed), nil
+}
+
+// deadLetter copies a message that has exceeded cfg.MaxDeliveries onto
+// the dead-letter stream and acknowledges the original so it stops being
+// redelivered.
+func (q *StreamsQueue) deadLetter(id string) error {
+	entries, err := q.client.XRangeN(q.cfg.Key, id, id, 1).Result()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 1 {
+		err := q.client.XAdd(&redis.XAddArgs{
+			Stream: q.cfg.Key + DeadLetterSuffix,
+			Values: entries[0].Values,
+		}).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return q.client.XAck(q.cfg.Key, q.cfg.Group, id).Err()
+}
@@ -0,0 +1,103 @@
+// Package redisclient builds a redis.UniversalClient from a single
+// connection string, so the three services don't each have to know
+// whether they're talking to a standalone instance, a Sentinel-fronted
+// master, or a Cluster.
+package redisclient
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+// Config holds the parsed fields of a connection string.
+type Config struct {
+	// Addrs is one address for a standalone instance, a seed list of
+	// cluster node addresses, or a list of Sentinel addresses when
+	// SentinelMaster is set.
+	Addrs []string
+	// DB is the database index. Ignored by cluster clients.
+	DB       int
+	Password string
+	// SentinelMaster, if set, selects the Sentinel failover client and
+	// names the master to follow. Addrs must then list Sentinel nodes.
+	SentinelMaster string
+	// TLS enables TLS on the connection using the default tls.Config.
+	TLS bool
+}
+
+// ParseConfig parses a connection string of the form
+//
+//	addrs=host1:6379,host2:6379 db=0 sentinel_master=mymaster password=secret tls=true
+//
+// Fields are space-separated key=value pairs; only addrs is required.
+func ParseConfig(s string) (Config, error) {
+	var cfg Config
+
+	for _, field := range strings.Fields(s) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("redisclient: malformed field %q", field)
+		}
+
+		switch key {
+		case "addrs":
+			cfg.Addrs = strings.Split(value, ",")
+		case "db":
+			db, err := strconv.Atoi(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("redisclient: invalid db %q: %w", value, err)
+			}
+			cfg.DB = db
+		case "password":
+			cfg.Password = value
+		case "sentinel_master":
+			cfg.SentinelMaster = value
+		case "tls":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("redisclient: invalid tls %q: %w", value, err)
+			}
+			cfg.TLS = enabled
+		default:
+			return Config{}, fmt.Errorf("redisclient: unknown field %q", key)
+		}
+	}
+
+	if len(cfg.Addrs) == 0 {
+		return Config{}, fmt.Errorf("redisclient: addrs is required")
+	}
+
+	return cfg, nil
+}
+
+// New parses s and returns a connected redis.UniversalClient: a
+// *redis.Client for a single addr with no sentinel_master, a Sentinel
+// failover client when sentinel_master is set, or a *redis.ClusterClient
+// when addrs lists more than one node.
+func New(s string) (redis.UniversalClient, error) {
+	cfg, err := ParseConfig(s)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:      cfg.Addrs,
+		DB:         cfg.DB,
+		Password:   cfg.Password,
+		MasterName: cfg.SentinelMaster,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{}
+	}
+
+	client := redis.NewUniversalClient(opts)
+	if _, err := client.Ping().Result(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
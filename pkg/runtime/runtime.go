@@ -0,0 +1,150 @@
+// Package runtime provides the shared start/stop lifecycle for the
+// three acme services: tick on an interval, optionally serve HTTP,
+// optionally run extra background loops, and on SIGINT/SIGTERM stop
+// cleanly — letting in-flight work finish, draining the HTTP server,
+// and closing the Redis client — rather than exiting mid-operation.
+package runtime
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultGracePeriod bounds Server.Shutdown when Options.GracePeriod
+// isn't set.
+const defaultGracePeriod = 30 * time.Second
+
+// Options configures Run.
+type Options struct {
+	// Interval is how often Tick is called. Ignored if Tick is nil.
+	Interval time.Duration
+	// Tick performs one unit of work per Interval. Run invokes it from
+	// a single goroutine, so once ctx is cancelled any Tick already in
+	// progress is guaranteed to finish before Run closes Client — a
+	// SIGTERM never cuts off a write partway through.
+	Tick func(ctx context.Context) error
+
+	// Server, if set, is served via ListenAndServe and drained with
+	// Server.Shutdown(ctx) bounded by GracePeriod once ctx is
+	// cancelled.
+	Server *http.Server
+
+	// OnShutdown, if set, is called once as soon as the shutdown signal
+	// arrives, before Server starts draining — the right place to flip
+	// a readiness flag so load balancers stop routing new traffic
+	// while in-flight work finishes.
+	OnShutdown func()
+
+	// Background are additional loops tied to the same lifecycle as
+	// Tick: Run starts each in its own goroutine with ctx and waits for
+	// all of them to return before closing Client.
+	Background []func(ctx context.Context)
+
+	// Client is closed as the last shutdown step, once Server and
+	// Background have stopped, so no buffered writes are lost under
+	// it. Optional.
+	Client io.Closer
+
+	// GracePeriod bounds Server.Shutdown. Defaults to 30s.
+	GracePeriod time.Duration
+}
+
+// Run blocks, ticking and serving as configured by opts, until it
+// receives SIGINT or SIGTERM. It then calls OnShutdown, drains Server,
+// waits for the current Tick and any Background loop to finish (these
+// keep running while Server drains), and closes Client last. It returns nil on a clean shutdown; a non-nil error means
+// Server failed to start or Server.Shutdown or Client.Close failed.
+func Run(opts Options) error {
+	gracePeriod := opts.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultGracePeriod
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+
+	if opts.Tick != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runTicks(ctx, opts.Interval, opts.Tick)
+		}()
+	}
+
+	for _, bg := range opts.Background {
+		wg.Add(1)
+		go func(bg func(ctx context.Context)) {
+			defer wg.Done()
+			bg(ctx)
+		}(bg)
+	}
+
+	var serveErr error
+	if opts.Server != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := opts.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				serveErr = err
+				cancel()
+			}
+		}()
+	}
+
+	<-ctx.Done()
+
+	if opts.OnShutdown != nil {
+		opts.OnShutdown()
+	}
+
+	var shutdownErr error
+	if opts.Server != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), gracePeriod)
+		shutdownErr = opts.Server.Shutdown(shutdownCtx)
+		shutdownCancel()
+	}
+
+	wg.Wait()
+
+	if opts.Client != nil {
+		if err := opts.Client.Close(); err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+
+	if shutdownErr != nil {
+		return shutdownErr
+	}
+	return serveErr
+}
+
+// runTicks calls tick every interval until ctx is cancelled.
+func runTicks(ctx context.Context, interval time.Duration, tick func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = tick(ctx)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package runtime
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRunDrainsInFlightTick sends SIGTERM while a Tick call is in
+// flight and asserts the write it was about to make isn't dropped: Run
+// must let it finish before shutting down.
+func TestRunDrainsInFlightTick(t *testing.T) {
+	var mu sync.Mutex
+	var writes []int
+	started := make(chan struct{})
+	var once sync.Once
+
+	tick := func(ctx context.Context) error {
+		once.Do(func() { close(started) })
+
+		time.Sleep(50 * time.Millisecond) // simulate in-flight work
+
+		mu.Lock()
+		writes = append(writes, len(writes))
+		mu.Unlock()
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(Options{
+			Interval: 10 * time.Millisecond,
+			Tick:     tick,
+		})
+	}()
+
+	<-started
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to signal self: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after SIGTERM")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(writes) == 0 {
+		t.Fatal("in-flight tick was dropped instead of finishing")
+	}
+}
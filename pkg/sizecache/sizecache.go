@@ -0,0 +1,104 @@
+// Package sizecache implements a cache-aside layer in front of a single
+// slow-changing integer, such as a Redis LLEN. A background goroutine
+// refreshes the cached value on a fixed interval; reads are served from
+// the cache while it's fresh and fall back to fetching directly once it
+// goes stale, so a slow or paused refresher degrades to the old
+// round-trip-per-request behaviour rather than serving bad data.
+package sizecache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// Fetcher retrieves the current value directly from its source.
+type Fetcher func() (int64, error)
+
+type entry struct {
+	value     int64
+	fetchedAt time.Time
+}
+
+// Cache caches the result of a single Fetcher under key, treating it as
+// stale once it is older than ttl.
+type Cache struct {
+	store *lru.Cache[string, entry]
+	fetch Fetcher
+	key   string
+	ttl   time.Duration
+
+	hits, misses int64
+}
+
+// New returns a Cache that serves fetch's result for up to ttl before
+// treating it as stale.
+func New(key string, fetch Fetcher, ttl time.Duration) (*Cache, error) {
+	store, err := lru.New[string, entry](1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{store: store, fetch: fetch, key: key, ttl: ttl}, nil
+}
+
+// Run polls Fetcher on interval, refreshing the cached value, until ctx
+// is cancelled. Run is meant to be called in its own goroutine.
+func (c *Cache) Run(ctx context.Context, interval time.Duration) {
+	c.refresh()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+func (c *Cache) refresh() {
+	value, err := c.fetch()
+	if err != nil {
+		return
+	}
+
+	c.store.Add(c.key, entry{value: value, fetchedAt: time.Now()})
+}
+
+// Get returns the cached value if it's no older than ttl. Otherwise it's
+// a cache miss: Get fetches directly and caches the result for the next
+// caller.
+func (c *Cache) Get() (int64, error) {
+	if e, ok := c.store.Get(c.key); ok && time.Since(e.fetchedAt) <= c.ttl {
+		atomic.AddInt64(&c.hits, 1)
+		return e.value, nil
+	}
+
+	atomic.AddInt64(&c.misses, 1)
+
+	value, err := c.fetch()
+	if err != nil {
+		return 0, err
+	}
+
+	c.store.Add(c.key, entry{value: value, fetchedAt: time.Now()})
+	return value, nil
+}
+
+// Invalidate drops the cached value so the next Get always fetches
+// directly, for callers that know a mutation just changed it.
+func (c *Cache) Invalidate() {
+	c.store.Remove(c.key)
+}
+
+// Stats returns the running hit and miss counts since the cache was
+// created.
+func (c *Cache) Stats() (hits, misses int64) {
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses)
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"math/rand"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/go-redis/redis"
+
+	"github.com/cloud66-samples/acme/pkg/queue"
+	"github.com/cloud66-samples/acme/pkg/redisclient"
+	"github.com/cloud66-samples/acme/pkg/runtime"
 )
 
 const (
@@ -19,72 +22,72 @@ const (
 )
 
 var (
-	redisAddress string
-	client       *redis.Client
+	redisConn    string
+	queueBackend string
+	client       redis.UniversalClient
+	producer     queue.Producer
 )
 
 // this pushes new items onto a stack on a random cycle
 func main() {
-	flag.StringVar(&redisAddress, "redis", "localhost:6379", "Connection to redis")
+	flag.StringVar(&redisConn, "redis", "addrs=localhost:6379 db=0", "Redis connection string, e.g. \"addrs=host1:6379,host2:6379 db=0 sentinel_master=mymaster password=secret tls=true\"")
+	flag.StringVar(&queueBackend, "queue-backend", queue.ListBackend, "Queue backend to use: list or streams")
 	flag.Parse()
 
 	rand.Seed(time.Now().Unix())
 
-	cancel := make(chan os.Signal)
-	signal.Notify(cancel, os.Interrupt, syscall.SIGTERM)
-
 	err := setupClient()
 	if err != nil {
-		fmt.Printf("Failed to connect to Redis on %s due to %s\n", redisAddress, err.Error())
+		fmt.Printf("Failed to connect to Redis on %s due to %s\n", redisConn, err.Error())
+		os.Exit(1)
+	}
+
+	err = setupQueue()
+	if err != nil {
+		fmt.Printf("Failed to set up %s queue backend due to %s\n", queueBackend, err.Error())
 		os.Exit(1)
 	}
 
-	ticker := time.NewTicker(interval)
-	for {
-		select {
-		case <-cancel:
-			fmt.Println("Leaving...")
-			ticker.Stop()
-			os.Exit(1)
-		case <-ticker.C:
+	err = runtime.Run(runtime.Options{
+		Interval: interval,
+		Tick: func(ctx context.Context) error {
 			fmt.Println("Selling...")
-			err := sell()
-			if err != nil {
+			if err := sell(); err != nil {
 				fmt.Printf("Failed to sell due to %s\n", err.Error())
 			}
-		}
+			return nil
+		},
+		Client: client,
+	})
+	if err != nil {
+		fmt.Printf("Shutdown failed due to %s\n", err.Error())
+		os.Exit(1)
 	}
+
+	fmt.Println("Leaving...")
 }
 
 func setupClient() error {
-	client = redis.NewClient(&redis.Options{
-		Addr:     redisAddress,
-		Password: "",
-		DB:       0,
-	})
+	fmt.Printf("Connecting to Redis on %s\n", redisConn)
 
-	fmt.Printf("Connecting to Redis on %s\n", redisAddress)
-	_, err := client.Ping().Result()
+	var err error
+	client, err = redisclient.New(redisConn)
+	return err
+}
+
+func setupQueue() error {
+	var err error
+	producer, _, err = queue.New(client, queue.Config{
+		Backend: queueBackend,
+		Key:     keyName,
+		MaxLen:  10000,
+	})
 	return err
 }
 
 func sell() error {
 	count := rand.Intn(10)
-	for i := 0; i < count; i++ {
-		_, err := client.LPush(keyName, 1).Result()
-		if err != nil {
-			return err
-		}
-	}
-	_, err := client.LPush(histogram, count).Result()
-	if err != nil {
-		return err
-	}
 
-	_, err = client.LTrim(histogram, 0, 100).Result()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	_, err := producer.PushSellBatch("1", int64(count), histogram, 100)
+	return err
 }